@@ -0,0 +1,114 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+)
+
+/*
+Coffer is a container for a 32-byte cryptographic key that never keeps the
+raw key in memory as a single contiguous value. Internally it holds two
+halves, left and right, such that left XOR a SHA-256 hash of right
+reconstructs the key; View reassembles it into a short-lived Buffer on
+demand, and SetKey replaces both halves together under the coffer's lock
+so that View never combines a half of one key with a half of another.
+*/
+type Coffer struct {
+	sync.Mutex
+
+	left  *Buffer
+	right *Buffer
+}
+
+// NewCoffer returns a Coffer holding a fresh, randomly generated key.
+func NewCoffer() *Coffer {
+	c := new(Coffer)
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		Panic(err)
+	}
+	if err := c.SetKey(key); err != nil {
+		Panic(err)
+	}
+	Wipe(key)
+
+	return c
+}
+
+// View decrypts the coffer's two halves back into the key they represent,
+// returned in a secure Buffer the caller must Destroy once done with it.
+func (c *Coffer) View() (*Buffer, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	b, err := NewBuffer(len(c.left.Data()))
+	if err != nil {
+		return nil, err
+	}
+	mask := sha256.Sum256(c.right.Data())
+	for i := range b.Data() {
+		b.Data()[i] = c.left.Data()[i] ^ mask[i%len(mask)]
+	}
+
+	return b, nil
+}
+
+/*
+SetKey installs key as the coffer's protected contents, replacing whatever
+was held before. key is split into a fresh random right half and a left
+half masking key with a hash of right - the same split representation
+View reassembles with an XOR - so that neither half alone reveals
+anything about key.
+*/
+func (c *Coffer) SetKey(key []byte) error {
+	right, err := NewBuffer(len(key))
+	if err != nil {
+		return err
+	}
+	if _, err := rand.Read(right.Data()); err != nil {
+		right.Destroy()
+		return err
+	}
+
+	mask := sha256.Sum256(right.Data())
+
+	left, err := NewBuffer(len(key))
+	if err != nil {
+		right.Destroy()
+		return err
+	}
+	for i := range key {
+		left.Data()[i] = key[i] ^ mask[i%len(mask)]
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.left != nil {
+		c.left.Destroy()
+	}
+	if c.right != nil {
+		c.right.Destroy()
+	}
+	c.left = left
+	c.right = right
+
+	return nil
+}
+
+// Destroy wipes and releases both of the coffer's halves.
+func (c *Coffer) Destroy() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.left != nil {
+		c.left.Destroy()
+		c.left = nil
+	}
+	if c.right != nil {
+		c.right.Destroy()
+		c.right = nil
+	}
+}
@@ -1,26 +1,37 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
 	"sync/atomic"
 	"unsafe"
 )
 
 var (
-	key unsafe.Pointer // *Coffer
+	key unsafe.Pointer // *keyEpoch
 )
 
 func init() {
 	if swapped := atomic.CompareAndSwapPointer(&key, nil, nil); swapped {
-		setKey(NewCoffer())
+		setKey(&keyEpoch{coffer: NewCoffer()})
 	}
 }
 
+// loadKeyEpoch returns the active coffer and the epoch id it's tagged
+// with as a single atomic snapshot, so callers that need both (sealing
+// code tagging a header, Open picking a coffer for an epoch) can never
+// observe a coffer from one epoch paired with the id of another - which
+// two independent atomic loads racing against Rekey/InitFromPassphrase
+// could otherwise produce.
+func loadKeyEpoch() *keyEpoch {
+	return (*keyEpoch)(atomic.LoadPointer(&key))
+}
+
 func getKey() *Coffer {
-	return (*Coffer)(atomic.LoadPointer(&key))
+	return loadKeyEpoch().coffer
 }
 
-func setKey(k *Coffer) {
+func setKey(k *keyEpoch) {
 	atomic.StorePointer(&key, unsafe.Pointer(k))
 }
 
@@ -32,12 +43,30 @@ Enclave is a sealed and encrypted container for sensitive data.
 */
 type Enclave struct {
 	Ciphertext []byte
+
+	// manual marks whether Ciphertext was allocated off the Go heap via
+	// memcall, in which case it must be freed with memcall.Free instead
+	// of being left for the garbage collector.
+	manual bool
+
+	// destroyed marks whether Destroy has already run, so a repeat call
+	// is a no-op instead of a double free.
+	destroyed bool
 }
 
 /*
 NewEnclave is a raw constructor for the Enclave object. The given buffer is wiped after the enclave is created.
+
+The ciphertext is sealed with DefaultCipherSuite; use NewEnclaveWithSuite to pick a different one.
 */
 func NewEnclave(buf []byte) (*Enclave, error) {
+	return NewEnclaveWithSuite(buf, DefaultCipherSuite)
+}
+
+/*
+NewEnclaveWithSuite behaves like NewEnclave, but seals the plaintext with the given CipherSuite instead of DefaultCipherSuite. The suite is recorded in the ciphertext header so Open dispatches to it automatically, meaning enclaves sealed under different suites can coexist.
+*/
+func NewEnclaveWithSuite(buf []byte, suite CipherSuite) (*Enclave, error) {
 	// Return an error if length < 1.
 	if len(buf) < 1 {
 		return nil, ErrNullEnclave
@@ -46,20 +75,17 @@ func NewEnclave(buf []byte) (*Enclave, error) {
 	// Create a new Enclave.
 	e := new(Enclave)
 
-	// Get a view of the key.
-	k, err := getKey().View()
+	// Tag the ciphertext with the key epoch and cipher suite it was
+	// sealed under, so Open can find its way back to the right key and
+	// algorithm even after Rekey has moved on.
+	tagged, err := sealTagged(buf, suite)
 	if err != nil {
+		Wipe(buf)
 		return nil, err
 	}
+	e.Ciphertext = tagged
 
-	// Encrypt the plaintext.
-	e.Ciphertext, err = Encrypt(buf, k.Data())
-	if err != nil {
-		Panic(err) // key is not 32 bytes long
-	}
-
-	// Destroy our copy of the key.
-	k.Destroy()
+	noteOp()
 
 	// Wipe the given buffer.
 	Wipe(buf)
@@ -101,20 +127,38 @@ Open decrypts an Enclave and puts the contents into a Buffer object. The given E
 The Buffer object should be destroyed after the contents are no longer needed.
 */
 func Open(e *Enclave) (*Buffer, error) {
+	if len(e.Ciphertext) < epochHeaderSize+suiteIDSize {
+		Panic("<memguard:core> ciphertext has invalid length") // ciphertext has invalid length
+	}
+
+	// Work out which key epoch and cipher suite this enclave was sealed
+	// under. The epoch's coffer may be the current one or a retired one
+	// still kept around for exactly this purpose.
+	epoch := binary.BigEndian.Uint32(e.Ciphertext[:epochHeaderSize])
+	suite, err := suiteByID(e.Ciphertext[epochHeaderSize])
+	if err != nil {
+		return nil, err
+	}
+	coffer, err := cofferForEpoch(epoch)
+	if err != nil {
+		return nil, err
+	}
+	body := e.Ciphertext[epochHeaderSize+suiteIDSize:]
+
 	// Allocate a secure Buffer to hold the decrypted data.
-	b, err := NewBuffer(len(e.Ciphertext) - Overhead)
+	b, err := NewBuffer(len(body) - suite.Overhead())
 	if err != nil {
 		Panic("<memguard:core> ciphertext has invalid length") // ciphertext has invalid length
 	}
 
 	// Grab a view of the key.
-	k, err := getKey().View()
+	k, err := coffer.View()
 	if err != nil {
 		return nil, err
 	}
 
 	// Decrypt the enclave into the buffer we created.
-	_, err = Decrypt(e.Ciphertext, k.Data(), b.Data())
+	_, err = suite.Open(body, k.Data(), b.Data())
 	if err != nil {
 		return nil, err
 	}
@@ -122,6 +166,8 @@ func Open(e *Enclave) (*Buffer, error) {
 	// Destroy our copy of the key.
 	k.Destroy()
 
+	noteOp()
+
 	// Return the contents of the Enclave inside a Buffer.
 	return b, nil
 }
@@ -130,5 +176,12 @@ func Open(e *Enclave) (*Buffer, error) {
 EnclaveSize returns the number of bytes of plaintext data stored inside an Enclave.
 */
 func EnclaveSize(e *Enclave) int {
-	return len(e.Ciphertext) - Overhead
+	if len(e.Ciphertext) < epochHeaderSize+suiteIDSize {
+		return 0
+	}
+	suite, err := suiteByID(e.Ciphertext[epochHeaderSize])
+	if err != nil {
+		return 0
+	}
+	return len(e.Ciphertext) - epochHeaderSize - suiteIDSize - suite.Overhead()
 }
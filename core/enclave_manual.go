@@ -0,0 +1,122 @@
+package core
+
+import (
+	"runtime"
+
+	"github.com/awnumar/memcall"
+)
+
+/*
+NewEnclaveManual behaves exactly like NewEnclave, except the returned
+Enclave's ciphertext is allocated with memcall.Alloc instead of being left
+to the Go runtime. That keeps it off the GC heap entirely, so it can't be
+scanned or copied elsewhere during a heap grow - useful for services that
+hold thousands of medium-sized enclaves (config secrets, TLS keys) where
+the extra GC pressure and copying would otherwise add up.
+
+The given buffer is wiped after the enclave is created. A finalizer frees
+the manually-allocated region once the Enclave is no longer reachable;
+callers that need deterministic cleanup should still call Destroy.
+*/
+func NewEnclaveManual(buf []byte) (*Enclave, error) {
+	return NewEnclaveManualWithSuite(buf, DefaultCipherSuite)
+}
+
+/*
+NewEnclaveManualWithSuite combines NewEnclaveManual's off-heap storage with NewEnclaveWithSuite's choice of CipherSuite.
+*/
+func NewEnclaveManualWithSuite(buf []byte, suite CipherSuite) (*Enclave, error) {
+	// Return an error if length < 1.
+	if len(buf) < 1 {
+		return nil, ErrNullEnclave
+	}
+
+	// Create a new Enclave.
+	e := new(Enclave)
+
+	tagged, err := sealTagged(buf, suite)
+	if err != nil {
+		Wipe(buf)
+		return nil, err
+	}
+
+	// Move the tagged ciphertext into manually-managed memory so it never
+	// lands on the Go heap.
+	region, err := memcall.Alloc(len(tagged))
+	if err != nil {
+		Wipe(buf)
+		Wipe(tagged)
+		return nil, err
+	}
+	copy(region, tagged)
+	Wipe(tagged)
+
+	e.Ciphertext = region
+	e.manual = true
+
+	runtime.SetFinalizer(e, func(e *Enclave) {
+		if e.manual {
+			memcall.Free(e.Ciphertext)
+		}
+	})
+
+	noteOp()
+
+	// Wipe the given buffer.
+	Wipe(buf)
+
+	return e, nil
+}
+
+/*
+Destroy releases an Enclave's ciphertext, making e unusable afterwards.
+For an Enclave returned by NewEnclaveManual or NewEnclaveManualWithSuite
+this frees the off-heap region immediately via memcall.Free instead of
+waiting on the finalizer; for an ordinary, Go-heap-backed Enclave it just
+wipes the ciphertext in place. Safe to call more than once.
+*/
+func (e *Enclave) Destroy() {
+	if e.destroyed {
+		return
+	}
+
+	if e.manual {
+		memcall.Free(e.Ciphertext)
+		runtime.SetFinalizer(e, nil)
+	} else {
+		Wipe(e.Ciphertext)
+	}
+
+	e.Ciphertext = nil
+	e.destroyed = true
+}
+
+/*
+SealManual behaves like Seal, but stores the resulting Enclave's
+ciphertext off the Go heap via NewEnclaveManual. The given Buffer is
+destroyed after the Enclave is created.
+*/
+func SealManual(b *Buffer) (*Enclave, error) {
+	// Check if the Buffer has been destroyed.
+	if !b.Alive() {
+		return nil, ErrBufferExpired
+	}
+
+	b.Melt() // Make the buffer mutable so that we can wipe it.
+
+	// Construct the Enclave from the Buffer's data.
+	e, err := func() (*Enclave, error) {
+		b.RLock() // Attain a read lock.
+		defer b.RUnlock()
+		return NewEnclaveManual(b.Data())
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// Destroy the Buffer object.
+	b.Destroy()
+
+	// Return the newly created Enclave.
+	return e, nil
+}
@@ -0,0 +1,18 @@
+package core
+
+import "testing"
+
+func TestDestroyIsIdempotentForHeapBackedEnclave(t *testing.T) {
+	e := &Enclave{Ciphertext: []byte("pretend-ciphertext")}
+
+	e.Destroy()
+	if e.Ciphertext != nil {
+		t.Fatalf("Ciphertext = %v, want nil after Destroy", e.Ciphertext)
+	}
+	if !e.destroyed {
+		t.Fatal("destroyed = false, want true after Destroy")
+	}
+
+	// A second call must be a no-op, not a double free.
+	e.Destroy()
+}
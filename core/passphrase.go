@@ -0,0 +1,255 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrEmptySalt is returned by InitFromPassphrase when given a zero-length salt.
+var ErrEmptySalt = errors.New("<memguard::core::ErrEmptySalt> salt must not be empty")
+
+// ErrInvalidEnvelope is returned when bytes handed to OpenFromBytes or
+// EnvelopeParams aren't a well-formed envelope produced by SealToBytes.
+var ErrInvalidEnvelope = errors.New("<memguard::core::ErrInvalidEnvelope> malformed passphrase envelope")
+
+// envelopeVersion identifies the envelope layout SealToBytes writes, so a
+// future format change can be detected by OpenFromBytes/EnvelopeParams.
+const envelopeVersion = 1
+
+/*
+Argon2Params configures the Argon2id key derivation InitFromPassphrase
+uses to turn a passphrase into a master key. The defaults in
+DefaultArgon2Params match the ≥64 MiB / 3 iterations / 1 lane baseline
+recommended for interactive use.
+*/
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Time        uint32 // iterations
+	Parallelism uint8
+	KeyLen      uint32 // bytes
+}
+
+// DefaultArgon2Params is a reasonable baseline for deriving a 32-byte
+// master key from a user passphrase: 64 MiB of memory, 3 iterations, a
+// single lane.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Time:        3,
+	Parallelism: 1,
+	KeyLen:      32,
+}
+
+// envelopeKeyInfo is the salt and Argon2Params a given passphrase epoch
+// was installed with, remembered so SealToBytes can describe whichever
+// epoch an Enclave actually happens to be sealed under.
+type envelopeKeyInfo struct {
+	salt   []byte
+	params Argon2Params
+}
+
+var (
+	envelopeMu sync.Mutex
+	// envelopeInfo is keyed by epoch id rather than holding a single pair,
+	// so that an enclave sealed under an older passphrase epoch still
+	// gets the right salt/params in SealToBytes even after a later
+	// InitFromPassphrase call has installed a different one.
+	envelopeInfo = make(map[uint32]envelopeKeyInfo)
+)
+
+// ErrEnvelopeKeyUnknown is returned by SealToBytes when the Enclave it was
+// given was sealed under a passphrase epoch this process never installed
+// via InitFromPassphrase (or one it has since forgotten).
+var ErrEnvelopeKeyUnknown = errors.New("<memguard::core::ErrEnvelopeKeyUnknown> enclave's key epoch has no known passphrase salt/params")
+
+// passphraseEpochBit is set in every epoch id InitFromPassphrase installs,
+// reserving the top half of the id space for passphrase-derived keys so
+// they can never collide with the sequential ids Rekey hands out to
+// CSPRNG/ratchet-derived ones, even across process restarts.
+const passphraseEpochBit = uint32(1) << 31
+
+// passphraseEpoch deterministically derives the epoch id a given salt and
+// Argon2Params get tagged with. Unlike Rekey's sequential ids - which only
+// mean anything within the process that generated them - this is the same
+// value every time for the same salt and params, in any process. That's
+// what lets OpenFromBytes find the right key again after a restart: as
+// long as InitFromPassphrase is called with the envelope's own salt and
+// params before Open, the epoch it installs matches the one embedded in
+// the persisted ciphertext exactly, without needing the (necessarily
+// process-local) retained-epoch ring at all.
+func passphraseEpoch(salt []byte, params Argon2Params) uint32 {
+	h := sha256.New()
+	h.Write(salt)
+
+	var p [13]byte
+	binary.BigEndian.PutUint32(p[0:4], params.Memory)
+	binary.BigEndian.PutUint32(p[4:8], params.Time)
+	p[8] = params.Parallelism
+	binary.BigEndian.PutUint32(p[9:13], params.KeyLen)
+	h.Write(p[:])
+
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint32(sum[:4]) | passphraseEpochBit
+}
+
+/*
+InitFromPassphrase derives the global coffer key from pw using Argon2id with
+the given salt and params, and installs it in place of whatever key is
+currently active (the CSPRNG-generated one from init, or a prior
+passphrase-derived key). pw is not destroyed.
+
+The installed key is tagged with a deterministic epoch id computed from
+salt and params (see passphraseEpoch), not the next sequential id Rekey
+would use, so that calling InitFromPassphrase again with the same salt and
+params - typically after a process restart - reproduces the exact epoch id
+enclaves sealed under it were tagged with. That is what makes SealToBytes/
+OpenFromBytes round-trip through a restart: the sequential ids Rekey hands
+out only ever mean anything within the process that issued them, and
+enclaves sealed under one of those after InitFromPassphrase will not
+survive a restart regardless, which is the intended behavior of Rekey's
+forward secrecy.
+
+The outgoing key is retired into the same retained-epoch ring Rekey uses,
+so enclaves sealed before this call remain openable for up to
+maxRetainedEpochs further rotations. salt and params are remembered so a
+subsequent SealToBytes knows how to describe this key in its envelope.
+*/
+func InitFromPassphrase(pw *Buffer, salt []byte, params Argon2Params) error {
+	if len(salt) == 0 {
+		return ErrEmptySalt
+	}
+	if !pw.Alive() {
+		return ErrBufferExpired
+	}
+
+	pw.RLock()
+	derived := argon2.IDKey(pw.Data(), salt, params.Time, params.Memory, params.Parallelism, params.KeyLen)
+	pw.RUnlock()
+
+	newCoffer, err := newCofferFromKey(derived)
+	Wipe(derived)
+	if err != nil {
+		return err
+	}
+
+	rekeyMu.Lock()
+	defer rekeyMu.Unlock()
+
+	old := loadKeyEpoch()
+	retire(old.id, old.coffer)
+
+	id := passphraseEpoch(salt, params)
+	setKey(&keyEpoch{id: id, coffer: newCoffer})
+	atomic.StoreUint64(&opsSinceRekey, 0)
+
+	envelopeMu.Lock()
+	envelopeInfo[id] = envelopeKeyInfo{salt: append([]byte(nil), salt...), params: params}
+	envelopeMu.Unlock()
+
+	return nil
+}
+
+/*
+SealToBytes serializes e into a self-describing envelope containing the
+salt and Argon2Params that were passed to InitFromPassphrase for e's own
+key epoch - not whichever call happened most recently - alongside the
+enclave's tagged ciphertext, so the envelope can be written to disk and
+later reopened with nothing but the original passphrase. It is an error
+to call SealToBytes on an enclave whose epoch isn't a passphrase-derived
+one this process still remembers.
+*/
+func SealToBytes(e *Enclave) ([]byte, error) {
+	if len(e.Ciphertext) < epochHeaderSize {
+		return nil, ErrInvalidEnvelope
+	}
+	epoch := binary.BigEndian.Uint32(e.Ciphertext[:epochHeaderSize])
+
+	envelopeMu.Lock()
+	info, ok := envelopeInfo[epoch]
+	envelopeMu.Unlock()
+
+	if !ok {
+		return nil, ErrEnvelopeKeyUnknown
+	}
+	salt, params := info.salt, info.params
+
+	out := make([]byte, 0, 1+2+len(salt)+13+len(e.Ciphertext))
+	out = append(out, envelopeVersion)
+
+	saltLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(saltLen, uint16(len(salt)))
+	out = append(out, saltLen...)
+	out = append(out, salt...)
+
+	var paramBuf [13]byte
+	binary.BigEndian.PutUint32(paramBuf[0:4], params.Memory)
+	binary.BigEndian.PutUint32(paramBuf[4:8], params.Time)
+	paramBuf[8] = params.Parallelism
+	binary.BigEndian.PutUint32(paramBuf[9:13], params.KeyLen)
+	out = append(out, paramBuf[:]...)
+
+	out = append(out, e.Ciphertext...)
+
+	return out, nil
+}
+
+/*
+EnvelopeParams extracts the salt and Argon2Params embedded in an envelope
+produced by SealToBytes, without touching the ciphertext. An application
+reopening a persisted secret calls this first to learn how to derive the
+key from the passphrase it prompts for, then calls InitFromPassphrase
+with the result before OpenFromBytes and Open.
+*/
+func EnvelopeParams(data []byte) ([]byte, Argon2Params, error) {
+	salt, params, _, err := parseEnvelope(data)
+	return salt, params, err
+}
+
+/*
+OpenFromBytes reverses SealToBytes, returning the Enclave whose
+ciphertext was embedded in the envelope. It assumes the caller has
+already installed the right key via InitFromPassphrase (typically using
+the salt and params obtained from EnvelopeParams); OpenFromBytes itself
+does not derive any key, it only reconstructs the Enclave so that core.Open
+can decrypt it.
+*/
+func OpenFromBytes(data []byte) (*Enclave, error) {
+	_, _, ciphertext, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Enclave{Ciphertext: ciphertext}, nil
+}
+
+func parseEnvelope(data []byte) ([]byte, Argon2Params, []byte, error) {
+	if len(data) < 1 || data[0] != envelopeVersion {
+		return nil, Argon2Params{}, nil, ErrInvalidEnvelope
+	}
+	data = data[1:]
+
+	if len(data) < 2 {
+		return nil, Argon2Params{}, nil, ErrInvalidEnvelope
+	}
+	saltLen := int(binary.BigEndian.Uint16(data[:2]))
+	data = data[2:]
+
+	if len(data) < saltLen+13 {
+		return nil, Argon2Params{}, nil, ErrInvalidEnvelope
+	}
+	salt := data[:saltLen]
+	data = data[saltLen:]
+
+	params := Argon2Params{
+		Memory:      binary.BigEndian.Uint32(data[0:4]),
+		Time:        binary.BigEndian.Uint32(data[4:8]),
+		Parallelism: data[8],
+		KeyLen:      binary.BigEndian.Uint32(data[9:13]),
+	}
+	ciphertext := data[13:]
+
+	return salt, params, ciphertext, nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPassphraseEpochIsDeterministic(t *testing.T) {
+	salt := []byte("some-salt")
+	params := DefaultArgon2Params
+
+	a := passphraseEpoch(salt, params)
+	b := passphraseEpoch(salt, params)
+	if a != b {
+		t.Fatalf("passphraseEpoch(%q, %+v) = %d, then %d; want the same value both times", salt, params, a, b)
+	}
+	if a&passphraseEpochBit == 0 {
+		t.Fatalf("passphraseEpoch(%q, %+v) = %d, want the passphrase bit set", salt, params, a)
+	}
+
+	if other := passphraseEpoch([]byte("different-salt"), params); other == a {
+		t.Fatalf("passphraseEpoch with a different salt produced the same id %d", a)
+	}
+
+	otherParams := params
+	otherParams.Time++
+	if other := passphraseEpoch(salt, otherParams); other == a {
+		t.Fatalf("passphraseEpoch with different params produced the same id %d", a)
+	}
+}
+
+func TestSealToBytesUsesEnclaveOwnEpoch(t *testing.T) {
+	oldA := envelopeKeyInfo{salt: []byte("salt-a"), params: Argon2Params{Memory: 1, Time: 1, Parallelism: 1, KeyLen: 32}}
+	newB := envelopeKeyInfo{salt: []byte("salt-b"), params: Argon2Params{Memory: 2, Time: 2, Parallelism: 1, KeyLen: 32}}
+
+	envelopeMu.Lock()
+	envelopeInfo[1] = oldA
+	envelopeInfo[2] = newB
+	envelopeMu.Unlock()
+	defer func() {
+		envelopeMu.Lock()
+		delete(envelopeInfo, 1)
+		delete(envelopeInfo, 2)
+		envelopeMu.Unlock()
+	}()
+
+	// An enclave sealed under the older epoch (1) must still get epoch
+	// 1's salt/params, even though epoch 2 was installed more recently.
+	header := make([]byte, epochHeaderSize)
+	binary.BigEndian.PutUint32(header, 1)
+	e := &Enclave{Ciphertext: append(header, []byte("ciphertext")...)}
+
+	envelope, err := SealToBytes(e)
+	if err != nil {
+		t.Fatalf("SealToBytes: %v", err)
+	}
+
+	gotSalt, gotParams, err := EnvelopeParams(envelope)
+	if err != nil {
+		t.Fatalf("EnvelopeParams: %v", err)
+	}
+	if string(gotSalt) != string(oldA.salt) || gotParams != oldA.params {
+		t.Fatalf("SealToBytes described epoch 1 as salt=%q params=%+v, want salt=%q params=%+v",
+			gotSalt, gotParams, oldA.salt, oldA.params)
+	}
+}
+
+func TestSealToBytesUnknownEpoch(t *testing.T) {
+	header := make([]byte, epochHeaderSize)
+	binary.BigEndian.PutUint32(header, 0xDEADBEEF)
+	e := &Enclave{Ciphertext: append(header, []byte("ciphertext")...)}
+
+	if _, err := SealToBytes(e); err != ErrEnvelopeKeyUnknown {
+		t.Fatalf("SealToBytes on an unknown epoch error = %v, want ErrEnvelopeKeyUnknown", err)
+	}
+}
+
+func buildEnvelope(t *testing.T, salt []byte, params Argon2Params, ciphertext []byte) []byte {
+	t.Helper()
+
+	out := []byte{envelopeVersion}
+
+	saltLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(saltLen, uint16(len(salt)))
+	out = append(out, saltLen...)
+	out = append(out, salt...)
+
+	var paramBuf [13]byte
+	binary.BigEndian.PutUint32(paramBuf[0:4], params.Memory)
+	binary.BigEndian.PutUint32(paramBuf[4:8], params.Time)
+	paramBuf[8] = params.Parallelism
+	binary.BigEndian.PutUint32(paramBuf[9:13], params.KeyLen)
+	out = append(out, paramBuf[:]...)
+
+	return append(out, ciphertext...)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	params := DefaultArgon2Params
+	ciphertext := []byte("pretend this is a tagged, encrypted enclave")
+
+	envelope := buildEnvelope(t, salt, params, ciphertext)
+
+	gotSalt, gotParams, err := EnvelopeParams(envelope)
+	if err != nil {
+		t.Fatalf("EnvelopeParams: %v", err)
+	}
+	if !bytes.Equal(gotSalt, salt) {
+		t.Fatalf("EnvelopeParams salt = %q, want %q", gotSalt, salt)
+	}
+	if gotParams != params {
+		t.Fatalf("EnvelopeParams params = %+v, want %+v", gotParams, params)
+	}
+
+	e, err := OpenFromBytes(envelope)
+	if err != nil {
+		t.Fatalf("OpenFromBytes: %v", err)
+	}
+	if !bytes.Equal(e.Ciphertext, ciphertext) {
+		t.Fatalf("OpenFromBytes ciphertext = %q, want %q", e.Ciphertext, ciphertext)
+	}
+}
+
+func TestEnvelopeRejectsMalformedInput(t *testing.T) {
+	cases := map[string][]byte{
+		"empty":          {},
+		"wrong version":  {envelopeVersion + 1, 0, 0},
+		"truncated salt": {envelopeVersion, 0, 5, 'a', 'b'},
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := EnvelopeParams(data); err != ErrInvalidEnvelope {
+				t.Fatalf("EnvelopeParams(%v) error = %v, want ErrInvalidEnvelope", data, err)
+			}
+			if _, err := OpenFromBytes(data); err != ErrInvalidEnvelope {
+				t.Fatalf("OpenFromBytes(%v) error = %v, want ErrInvalidEnvelope", data, err)
+			}
+		})
+	}
+}
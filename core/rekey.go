@@ -0,0 +1,238 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// epochHeaderSize is the number of bytes an Enclave's ciphertext is
+// prefixed with to record the key epoch it was sealed under.
+const epochHeaderSize = 4
+
+// maxRetainedEpochs bounds how many rotated-out keys are kept around
+// purely so that enclaves sealed before a Rekey remain openable. Once an
+// epoch ages past this window it is wiped for good, which is what gives
+// Rekey its forward secrecy: there's no way back to a purged key.
+const maxRetainedEpochs = 8
+
+// ErrEpochPurged is returned by Open when an Enclave was sealed under a
+// key epoch that has since been rotated out and wiped.
+var ErrEpochPurged = errors.New("<memguard::core::ErrEpochPurged> enclave was sealed under a key epoch that has been purged")
+
+// keyEpoch bundles a Coffer with the epoch id enclaves sealed under it
+// get tagged with. The pair is always read and replaced together (see
+// loadKeyEpoch/setKey and Rekey/InitFromPassphrase) so that sealing code
+// can never observe a coffer from one epoch alongside the id of another.
+type keyEpoch struct {
+	id     uint32
+	coffer *Coffer
+}
+
+type retiredEpoch struct {
+	id     uint32
+	coffer *Coffer
+}
+
+var (
+	retainedMu sync.Mutex
+	retained   []retiredEpoch // most recently retired first, capped at maxRetainedEpochs
+
+	rekeyMu sync.Mutex // serializes Rekey calls
+
+	// rekeySeq is the last sequential epoch id Rekey handed out; guarded
+	// by rekeyMu.
+	rekeySeq uint32
+)
+
+// currentEpoch returns the epoch identifier new enclaves are tagged with.
+func currentEpoch() uint32 {
+	return loadKeyEpoch().id
+}
+
+// cofferForEpoch returns the Coffer holding the key for the given epoch,
+// whether that's the current one or one still sitting in the retired ring.
+func cofferForEpoch(id uint32) (*Coffer, error) {
+	if active := loadKeyEpoch(); id == active.id {
+		return active.coffer, nil
+	}
+
+	retainedMu.Lock()
+	defer retainedMu.Unlock()
+	for _, r := range retained {
+		if r.id == id {
+			return r.coffer, nil
+		}
+	}
+
+	return nil, ErrEpochPurged
+}
+
+// newCofferFromKey wraps a raw, already-derived key - from the HMAC
+// ratchet below or the Argon2id derivation in InitFromPassphrase - in a
+// fresh Coffer via SetKey, the same protected split form the
+// CSPRNG-generated key gets at init.
+func newCofferFromKey(rawKey []byte) (*Coffer, error) {
+	c := new(Coffer)
+	if err := c.SetKey(rawKey); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// retire pushes a coffer that has just been rotated out into the retained
+// ring, evicting and destroying the oldest entry once the ring is full.
+func retire(id uint32, c *Coffer) {
+	retainedMu.Lock()
+	defer retainedMu.Unlock()
+
+	retained = append([]retiredEpoch{{id: id, coffer: c}}, retained...)
+
+	for len(retained) > maxRetainedEpochs {
+		oldest := retained[len(retained)-1]
+		oldest.coffer.Destroy()
+		retained = retained[:len(retained)-1]
+	}
+}
+
+/*
+Rekey rotates the global enclave key. The replacement is derived from the
+current key with an HMAC-SHA256 ratchet step - newKey = HMAC(oldKey,
+freshEntropy) - so possession of the new key reveals nothing about the
+old one, the way a double ratchet advances its root key.
+
+The outgoing key is retained, good for decryption only, for up to
+maxRetainedEpochs further rotations so that enclaves sealed before this
+call stay openable; once it falls out of that window it is destroyed and
+whatever it protected becomes permanently unrecoverable. Enclaves embed
+the id of the epoch they were sealed under, so Open selects the right key
+on its own - callers don't need to know a rotation ever happened.
+*/
+func Rekey() error {
+	rekeyMu.Lock()
+	defer rekeyMu.Unlock()
+
+	old := loadKeyEpoch()
+
+	v, err := old.coffer.View()
+	if err != nil {
+		return err
+	}
+
+	entropy := make([]byte, sha256.Size)
+	if _, err := rand.Read(entropy); err != nil {
+		v.Destroy()
+		return err
+	}
+
+	mac := hmac.New(sha256.New, v.Data())
+	mac.Write(entropy)
+	newKey := mac.Sum(nil)
+
+	v.Destroy()
+	Wipe(entropy)
+
+	newCoffer, err := newCofferFromKey(newKey)
+	Wipe(newKey)
+	if err != nil {
+		return err
+	}
+
+	retire(old.id, old.coffer)
+
+	// The new coffer and its epoch id become visible to sealing/opening
+	// code in one atomic swap, so nothing can ever observe one without
+	// the other. The id comes from Rekey's own counter rather than
+	// old.id+1, so a Rekey following InitFromPassphrase stays out of the
+	// half of the id space reserved by passphraseEpochBit.
+	rekeySeq++
+	setKey(&keyEpoch{id: rekeySeq &^ passphraseEpochBit, coffer: newCoffer})
+	atomic.StoreUint64(&opsSinceRekey, 0)
+
+	return nil
+}
+
+var (
+	opsSinceRekey uint64 // atomic
+	rekeyAfterOps uint64 // atomic; 0 disables op-count-based rekeying
+	rekeyInFlight uint32 // atomic bool; 1 while an op-count-triggered Rekey is running
+)
+
+// noteOp is called on every seal/open and triggers an asynchronous Rekey
+// once the threshold set by SetRekeyAfterOps is reached. A CAS guard
+// ensures only one such Rekey runs at a time: without it, every caller
+// that crosses the threshold before the async Rekey resets the counter
+// would spawn its own rotation, each one burning a slot in the retained
+// epoch ring and purging old epochs far sooner than the configured
+// policy implies.
+func noteOp() {
+	limit := atomic.LoadUint64(&rekeyAfterOps)
+	if limit == 0 {
+		return
+	}
+	if atomic.AddUint64(&opsSinceRekey, 1) >= limit {
+		if atomic.CompareAndSwapUint32(&rekeyInFlight, 0, 1) {
+			go func() {
+				defer atomic.StoreUint32(&rekeyInFlight, 0)
+				Rekey()
+			}()
+		}
+	}
+}
+
+/*
+SetRekeyAfterOps arranges for Rekey to run automatically once n Seal/Open
+operations have taken place since the last rotation. Passing zero (the
+default) disables operation-count-based rekeying.
+*/
+func SetRekeyAfterOps(n uint64) {
+	atomic.StoreUint64(&rekeyAfterOps, n)
+	atomic.StoreUint64(&opsSinceRekey, 0)
+}
+
+var (
+	rekeyPolicyMu sync.Mutex
+	rekeyTimer    *time.Timer
+	rekeySchedule uint64 // bumped by every SetRekeyInterval call
+)
+
+/*
+SetRekeyInterval arranges for Rekey to run automatically every d. Passing
+zero disables time-based rekeying. Safe to call repeatedly to change or
+cancel the schedule; long-running services typically call this once at
+startup to bound how much data is ever encrypted under a single key.
+*/
+func SetRekeyInterval(d time.Duration) {
+	rekeyPolicyMu.Lock()
+	defer rekeyPolicyMu.Unlock()
+
+	if rekeyTimer != nil {
+		rekeyTimer.Stop()
+		rekeyTimer = nil
+	}
+
+	rekeySchedule++
+
+	if d <= 0 {
+		return
+	}
+
+	gen := rekeySchedule
+	rekeyTimer = time.AfterFunc(d, func() { rekeyTick(d, gen) })
+}
+
+// rekeyTick runs one scheduled Rekey and re-arms the timer, unless a later
+// SetRekeyInterval call has replaced or cancelled the schedule it belongs to.
+func rekeyTick(d time.Duration, gen uint64) {
+	Rekey()
+
+	rekeyPolicyMu.Lock()
+	defer rekeyPolicyMu.Unlock()
+	if rekeyTimer != nil && gen == rekeySchedule {
+		rekeyTimer = time.AfterFunc(d, func() { rekeyTick(d, gen) })
+	}
+}
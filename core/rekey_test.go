@@ -0,0 +1,53 @@
+package core
+
+import "testing"
+
+func resetRetained(t *testing.T) {
+	t.Helper()
+	retainedMu.Lock()
+	retained = nil
+	retainedMu.Unlock()
+}
+
+func TestRetireEvictsOldestPastRingCap(t *testing.T) {
+	resetRetained(t)
+
+	for i := uint32(0); i < maxRetainedEpochs+2; i++ {
+		retire(i, NewCoffer())
+	}
+
+	retainedMu.Lock()
+	defer retainedMu.Unlock()
+
+	if len(retained) != maxRetainedEpochs {
+		t.Fatalf("len(retained) = %d, want %d", len(retained), maxRetainedEpochs)
+	}
+	for _, r := range retained {
+		if r.id < 2 {
+			t.Fatalf("epoch %d should have aged out of a ring capped at %d", r.id, maxRetainedEpochs)
+		}
+	}
+}
+
+func TestCofferForEpochFindsRetainedCoffer(t *testing.T) {
+	resetRetained(t)
+
+	want := NewCoffer()
+	retire(99, want)
+
+	got, err := cofferForEpoch(99)
+	if err != nil {
+		t.Fatalf("cofferForEpoch(99): %v", err)
+	}
+	if got != want {
+		t.Fatal("cofferForEpoch(99) returned a different coffer than the one retired")
+	}
+}
+
+func TestCofferForEpochPurged(t *testing.T) {
+	resetRetained(t)
+
+	if _, err := cofferForEpoch(123456); err != ErrEpochPurged {
+		t.Fatalf("cofferForEpoch on an unknown epoch = %v, want ErrEpochPurged", err)
+	}
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// streamFrameSize is the amount of plaintext sealed into each frame of a
+// StreamingEnclave. 64 KiB keeps the transient buffer small while still
+// amortizing AEAD overhead across a reasonable chunk of data.
+const streamFrameSize = 64 * 1024
+
+// streamFrameTypeSize is the one-byte frame-type prefix included in every
+// frame's plaintext: 0 for a data frame, 1 for the final frame. Without
+// it, a ciphertext truncated exactly on a frame boundary would decrypt
+// and authenticate cleanly while silently dropping the tail.
+const streamFrameTypeSize = 1
+
+const (
+	streamFrameData  byte = 0
+	streamFrameFinal byte = 1
+)
+
+// ErrStreamClosed is returned by EnclaveWriter.Write/Close once the
+// writer has already been closed.
+var ErrStreamClosed = errors.New("<memguard::core::ErrStreamClosed> enclave writer is already closed")
+
+// ErrTruncatedStream is returned when a StreamingEnclave's frames run out
+// before a final frame has been seen, meaning the ciphertext was cut
+// short somewhere between sealing and opening.
+var ErrTruncatedStream = errors.New("<memguard::core::ErrTruncatedStream> streaming enclave ended without a terminator frame")
+
+/*
+StreamingEnclave is the sealed form of data written through an
+EnclaveWriter: a sequence of independently authenticated frames, each
+encrypted with the coffer key under a nonce derived from a random base
+plus the frame's index. It holds no plaintext and, like Enclave, is safe
+to store and pass around.
+*/
+type StreamingEnclave struct {
+	epoch     uint32
+	baseNonce []byte
+	frames    [][]byte
+}
+
+func frameNonce(baseNonce []byte, counter uint64) []byte {
+	nonce := append([]byte(nil), baseNonce...)
+	ctr := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(ctr, binary.BigEndian.Uint64(ctr)^counter)
+	return nonce
+}
+
+/*
+EnclaveWriter is an io.WriteCloser that seals everything written to it
+into a StreamingEnclave, one streamFrameSize frame at a time, so callers
+can encrypt multi-megabyte secrets (private key bundles, backup blobs)
+without first assembling the whole plaintext in a single secure buffer.
+*/
+type EnclaveWriter struct {
+	se         *StreamingEnclave
+	aead       cipher.AEAD
+	counter    uint64
+	pending    *Buffer // secure scratch space, exactly streamFrameSize long
+	pendingLen int
+	closed     bool
+}
+
+/*
+NewEnclaveWriter returns an EnclaveWriter ready to accept plaintext. Call
+Close when done to seal the final frame; the result is available from
+Enclave.
+*/
+func NewEnclaveWriter() (*EnclaveWriter, error) {
+	// Snapshot the coffer and its epoch id together so a concurrent
+	// Rekey can't leave this writer encrypting under one key while the
+	// StreamingEnclave claims another (see sealTagged for the same
+	// concern on the non-streaming path).
+	active := loadKeyEpoch()
+
+	k, err := active.coffer.View()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(k.Data())
+	k.Destroy()
+	if err != nil {
+		return nil, err
+	}
+
+	baseNonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(baseNonce); err != nil {
+		return nil, err
+	}
+
+	// pending is the one window of plaintext this writer ever holds,
+	// kept in a secure Buffer rather than a plain slice so it's never
+	// sitting unprotected on the ordinary Go heap.
+	pending, err := NewBuffer(streamFrameSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnclaveWriter{
+		se:      &StreamingEnclave{epoch: active.id, baseNonce: baseNonce},
+		aead:    aead,
+		pending: pending,
+	}, nil
+}
+
+func (ew *EnclaveWriter) sealFrame(data []byte, frameType byte) error {
+	scratch, err := NewBuffer(streamFrameTypeSize + len(data))
+	if err != nil {
+		return err
+	}
+	scratch.Data()[0] = frameType
+	copy(scratch.Data()[streamFrameTypeSize:], data)
+
+	ciphertext := ew.aead.Seal(nil, frameNonce(ew.se.baseNonce, ew.counter), scratch.Data(), nil)
+	ew.se.frames = append(ew.se.frames, ciphertext)
+	ew.counter++
+
+	scratch.Destroy()
+	noteOp()
+
+	return nil
+}
+
+// Write implements io.Writer, buffering input into the secure pending
+// Buffer and sealing a frame every time streamFrameSize bytes accumulate.
+func (ew *EnclaveWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, ErrStreamClosed
+	}
+
+	total := len(p)
+	for len(p) > 0 {
+		free := streamFrameSize - ew.pendingLen
+		n := free
+		if n > len(p) {
+			n = len(p)
+		}
+		copy(ew.pending.Data()[ew.pendingLen:], p[:n])
+		ew.pendingLen += n
+		p = p[n:]
+
+		if ew.pendingLen == streamFrameSize {
+			if err := ew.sealFrame(ew.pending.Data(), streamFrameData); err != nil {
+				return total - len(p), err
+			}
+			Wipe(ew.pending.Data())
+			ew.pendingLen = 0
+		}
+	}
+
+	return total, nil
+}
+
+// Close seals whatever plaintext remains, tagged as the final frame, and
+// makes the writer unusable. It always succeeds once, even with no
+// buffered data, so that Enclave's frame list has a terminator.
+func (ew *EnclaveWriter) Close() error {
+	if ew.closed {
+		return ErrStreamClosed
+	}
+
+	err := ew.sealFrame(ew.pending.Data()[:ew.pendingLen], streamFrameFinal)
+	ew.pending.Destroy()
+	ew.pending = nil
+	ew.closed = true
+
+	return err
+}
+
+// Enclave returns the StreamingEnclave being built. It may be called
+// before Close, but the result is only complete - with a terminator
+// frame - once Close has returned.
+func (ew *EnclaveWriter) Enclave() *StreamingEnclave {
+	return ew.se
+}
+
+/*
+OpenStream returns an io.ReadCloser that decrypts a StreamingEnclave
+frame by frame, the streaming counterpart to Open. At most two frames'
+worth of plaintext - the one being read and the one just behind it -
+exist in secure Buffers at any moment; earlier frames are destroyed as
+soon as the reader moves past them.
+*/
+func OpenStream(se *StreamingEnclave) (io.ReadCloser, error) {
+	coffer, err := cofferForEpoch(se.epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := coffer.View()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(k.Data())
+	k.Destroy()
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{se: se, aead: aead}, nil
+}
+
+// streamReader implements io.ReadCloser over a StreamingEnclave, keeping
+// a rotating pair of secure Buffers so only ever one or two frames of
+// plaintext are resident at once.
+type streamReader struct {
+	se    *StreamingEnclave
+	aead  cipher.AEAD
+	idx   int
+	bufs  [2]*Buffer
+	cur   int
+	off   int
+	final bool
+}
+
+func (sr *streamReader) advance() error {
+	if sr.idx >= len(sr.se.frames) {
+		return ErrTruncatedStream
+	}
+
+	frame := sr.se.frames[sr.idx]
+	nonce := frameNonce(sr.se.baseNonce, uint64(sr.idx))
+
+	// Free the buffer two frames behind before decrypting the next one, so
+	// no more than two frames of plaintext are ever resident.
+	next := (sr.cur + 1) % len(sr.bufs)
+	if sr.bufs[next] != nil {
+		sr.bufs[next].Destroy()
+		sr.bufs[next] = nil
+	}
+
+	// Decrypt straight into a secure Buffer sized for this frame's
+	// plaintext, rather than letting AEAD.Open hand back a plain Go
+	// slice - the frame-type prefix comes along for the ride and gets
+	// wiped with the rest once we've read it.
+	scratch, err := NewBuffer(len(frame) - sr.aead.Overhead())
+	if err != nil {
+		return err
+	}
+
+	if _, err := sr.aead.Open(scratch.Data()[:0], nonce, frame, nil); err != nil {
+		scratch.Destroy()
+		return err
+	}
+	sr.idx++
+	noteOp()
+
+	final := scratch.Data()[0] == streamFrameFinal
+
+	sr.bufs[next] = scratch
+	sr.cur = next
+	sr.off = streamFrameTypeSize
+	sr.final = final
+
+	return nil
+}
+
+// Read implements io.Reader.
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for {
+		buf := sr.bufs[sr.cur]
+		if buf != nil && sr.off < len(buf.Data()) {
+			n := copy(p, buf.Data()[sr.off:])
+			sr.off += n
+			return n, nil
+		}
+
+		if sr.final {
+			return 0, io.EOF
+		}
+
+		if err := sr.advance(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close implements io.Closer, destroying any buffered plaintext.
+func (sr *streamReader) Close() error {
+	for i := range sr.bufs {
+		if sr.bufs[i] != nil {
+			sr.bufs[i].Destroy()
+			sr.bufs[i] = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package core
+
+import "testing"
+
+func TestFrameNonceDistinctPerCounter(t *testing.T) {
+	base := make([]byte, 24)
+	for i := range base {
+		base[i] = byte(i)
+	}
+
+	seen := make(map[string]bool)
+	for counter := uint64(0); counter < 8; counter++ {
+		nonce := frameNonce(base, counter)
+		if len(nonce) != len(base) {
+			t.Fatalf("frameNonce length = %d, want %d", len(nonce), len(base))
+		}
+		key := string(nonce)
+		if seen[key] {
+			t.Fatalf("frameNonce produced a repeat nonce at counter %d", counter)
+		}
+		seen[key] = true
+	}
+}
+
+func TestFrameNonceDeterministic(t *testing.T) {
+	base := []byte("0123456789abcdefghijklmn")
+
+	a := frameNonce(base, 42)
+	b := frameNonce(base, 42)
+	if string(a) != string(b) {
+		t.Fatalf("frameNonce(base, 42) is not deterministic: %x vs %x", a, b)
+	}
+}
+
+func TestFrameNonceDoesNotMutateBase(t *testing.T) {
+	base := []byte("0123456789abcdefghijklmn")
+	original := string(base)
+
+	frameNonce(base, 7)
+
+	if string(base) != original {
+		t.Fatalf("frameNonce mutated its base nonce: got %x, want %x", base, original)
+	}
+}
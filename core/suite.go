@@ -0,0 +1,262 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// suiteIDSize is the number of bytes an Enclave's ciphertext is prefixed
+// with (after the epoch header) to record which CipherSuite it was
+// sealed under.
+const suiteIDSize = 1
+
+// ErrUnknownCipherSuite is returned when an Enclave's ciphertext names a
+// cipher suite identifier that isn't registered.
+var ErrUnknownCipherSuite = errors.New("<memguard::core::ErrUnknownCipherSuite> enclave was sealed with an unregistered cipher suite")
+
+// ErrSuiteDecryptionFailed is returned by a CipherSuite's Open when the
+// ciphertext fails authentication.
+var ErrSuiteDecryptionFailed = errors.New("<memguard::core::ErrSuiteDecryptionFailed> ciphertext failed authentication")
+
+/*
+CipherSuite is the interface an AEAD scheme must implement to be usable
+as an Enclave's encryption backend. Seal and Open operate on whole
+buffers rather than streams, matching the way Enclave treats its
+plaintext as a single opaque blob.
+*/
+type CipherSuite interface {
+	// Seal encrypts and authenticates plaintext under key, returning a
+	// self-contained ciphertext (including nonce, if any) that Open can
+	// reverse given the same key.
+	Seal(plaintext, key []byte) ([]byte, error)
+
+	// Open authenticates and decrypts ciphertext under key, writing the
+	// recovered plaintext into out and returning its length. out must be
+	// at least len(ciphertext) - Overhead() bytes long.
+	Open(ciphertext, key, out []byte) (int, error)
+
+	// KeySize is the number of bytes of key material this suite expects.
+	KeySize() int
+
+	// Overhead is the number of bytes Seal adds on top of the plaintext
+	// (nonce plus authentication tag).
+	Overhead() int
+
+	// Name identifies the suite, e.g. for logging or diagnostics.
+	Name() string
+}
+
+type suiteEntry struct {
+	id    byte
+	suite CipherSuite
+}
+
+// suiteRegistry lists every CipherSuite an Enclave's header can name,
+// keyed by the single byte stored alongside the epoch. New entries must
+// only ever be appended; changing an existing id would make previously
+// sealed enclaves unopenable.
+var suiteRegistry = []suiteEntry{
+	{0, XChaCha20Poly1305{}},
+	{1, AES256GCM{}},
+	{2, SecretBox{}},
+}
+
+// DefaultCipherSuite is the suite NewEnclave and NewEnclaveManual use
+// when no suite is given explicitly, preserving the pre-existing
+// XChaCha20-Poly1305 behavior.
+var DefaultCipherSuite CipherSuite = XChaCha20Poly1305{}
+
+func suiteByID(id byte) (CipherSuite, error) {
+	for _, e := range suiteRegistry {
+		if e.id == id {
+			return e.suite, nil
+		}
+	}
+	return nil, ErrUnknownCipherSuite
+}
+
+func idForSuite(suite CipherSuite) (byte, error) {
+	for _, e := range suiteRegistry {
+		if e.suite.Name() == suite.Name() {
+			return e.id, nil
+		}
+	}
+	return 0, ErrUnknownCipherSuite
+}
+
+/*
+XChaCha20Poly1305 is the default CipherSuite, matching memguard's
+original Encrypt/Decrypt scheme. It is preferred for its resistance to
+nonce-reuse.
+*/
+type XChaCha20Poly1305 struct{}
+
+// Seal implements CipherSuite.
+func (XChaCha20Poly1305) Seal(plaintext, key []byte) ([]byte, error) {
+	return Encrypt(plaintext, key)
+}
+
+// Open implements CipherSuite.
+func (XChaCha20Poly1305) Open(ciphertext, key, out []byte) (int, error) {
+	return Decrypt(ciphertext, key, out)
+}
+
+// KeySize implements CipherSuite.
+func (XChaCha20Poly1305) KeySize() int { return 32 }
+
+// Overhead implements CipherSuite.
+func (XChaCha20Poly1305) Overhead() int { return Overhead }
+
+// Name implements CipherSuite.
+func (XChaCha20Poly1305) Name() string { return "xchacha20poly1305" }
+
+/*
+AES256GCM is a CipherSuite backed by AES-256 in GCM mode, offered for
+FIPS-constrained deployments and for hardware acceleration on AES-NI
+capable CPUs.
+*/
+type AES256GCM struct{}
+
+// KeySize implements CipherSuite.
+func (AES256GCM) KeySize() int { return 32 }
+
+// Overhead implements CipherSuite.
+func (AES256GCM) Overhead() int {
+	return 12 + 16 // GCM standard nonce plus authentication tag
+}
+
+// Name implements CipherSuite.
+func (AES256GCM) Name() string { return "aes-256-gcm" }
+
+// Seal implements CipherSuite.
+func (s AES256GCM) Seal(plaintext, key []byte) ([]byte, error) {
+	gcm, err := s.newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open implements CipherSuite.
+func (s AES256GCM) Open(ciphertext, key, out []byte) (int, error) {
+	gcm, err := s.newGCM(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return 0, ErrSuiteDecryptionFailed
+	}
+	nonce, body := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(out[:0], nonce, body, nil)
+	if err != nil {
+		return 0, ErrSuiteDecryptionFailed
+	}
+
+	return len(plaintext), nil
+}
+
+func (AES256GCM) newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+SecretBox is a CipherSuite backed by NaCl's secretbox construction
+(XSalsa20-Poly1305), provided for interoperability with other tools in
+the NaCl/libsodium ecosystem.
+*/
+type SecretBox struct{}
+
+// KeySize implements CipherSuite.
+func (SecretBox) KeySize() int { return 32 }
+
+// Overhead implements CipherSuite.
+func (SecretBox) Overhead() int {
+	return 24 + secretbox.Overhead // secretbox nonce plus Poly1305 tag
+}
+
+// Name implements CipherSuite.
+func (SecretBox) Name() string { return "nacl-secretbox" }
+
+// Seal implements CipherSuite.
+func (SecretBox) Seal(plaintext, key []byte) ([]byte, error) {
+	var k [32]byte
+	copy(k[:], key)
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	return secretbox.Seal(nonce[:], plaintext, &nonce, &k), nil
+}
+
+// Open implements CipherSuite.
+func (SecretBox) Open(ciphertext, key, out []byte) (int, error) {
+	if len(ciphertext) < 24 {
+		return 0, ErrSuiteDecryptionFailed
+	}
+
+	var k [32]byte
+	copy(k[:], key)
+
+	var nonce [24]byte
+	copy(nonce[:], ciphertext[:24])
+
+	plaintext, ok := secretbox.Open(out[:0], ciphertext[24:], &nonce, &k)
+	if !ok {
+		return 0, ErrSuiteDecryptionFailed
+	}
+
+	return len(plaintext), nil
+}
+
+// sealTagged encrypts buf under suite and the current coffer key,
+// prefixing the result with the key-epoch and cipher-suite header that
+// Open needs to reverse it.
+func sealTagged(buf []byte, suite CipherSuite) ([]byte, error) {
+	id, err := idForSuite(suite)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the coffer and the epoch it's tagged under as a single
+	// snapshot. Reading them separately would let a concurrent Rekey
+	// land in between, producing a ciphertext encrypted under the old
+	// key but tagged with the new epoch - which Open would then try to
+	// decrypt with the wrong key.
+	active := loadKeyEpoch()
+
+	k, err := active.coffer.View()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := suite.Seal(buf, k.Data())
+	if err != nil {
+		Panic(err) // key is not the right length for this suite
+	}
+	k.Destroy()
+
+	header := make([]byte, epochHeaderSize+suiteIDSize)
+	binary.BigEndian.PutUint32(header[:epochHeaderSize], active.id)
+	header[epochHeaderSize] = id
+
+	return append(header, ciphertext...), nil
+}
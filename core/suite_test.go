@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCipherSuiteRoundTrip(t *testing.T) {
+	suites := []CipherSuite{AES256GCM{}, SecretBox{}}
+
+	for _, suite := range suites {
+		t.Run(suite.Name(), func(t *testing.T) {
+			key := make([]byte, suite.KeySize())
+			if _, err := rand.Read(key); err != nil {
+				t.Fatalf("generating key: %v", err)
+			}
+
+			plaintext := []byte("the quick brown fox jumps over the lazy dog")
+
+			ciphertext, err := suite.Seal(plaintext, key)
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			if len(ciphertext) != len(plaintext)+suite.Overhead() {
+				t.Fatalf("ciphertext length = %d, want %d", len(ciphertext), len(plaintext)+suite.Overhead())
+			}
+
+			out := make([]byte, len(plaintext))
+			n, err := suite.Open(ciphertext, key, out)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			if n != len(plaintext) {
+				t.Fatalf("Open returned length %d, want %d", n, len(plaintext))
+			}
+			if !bytes.Equal(out, plaintext) {
+				t.Fatalf("Open produced %q, want %q", out, plaintext)
+			}
+		})
+	}
+}
+
+func TestCipherSuiteRejectsTamperedCiphertext(t *testing.T) {
+	suites := []CipherSuite{AES256GCM{}, SecretBox{}}
+
+	for _, suite := range suites {
+		t.Run(suite.Name(), func(t *testing.T) {
+			key := make([]byte, suite.KeySize())
+			if _, err := rand.Read(key); err != nil {
+				t.Fatalf("generating key: %v", err)
+			}
+
+			ciphertext, err := suite.Seal([]byte("secret"), key)
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			ciphertext[len(ciphertext)-1] ^= 0xFF
+
+			out := make([]byte, len("secret"))
+			if _, err := suite.Open(ciphertext, key, out); err == nil {
+				t.Fatal("Open succeeded on tampered ciphertext, want an error")
+			}
+		})
+	}
+}
+
+func TestSuiteRegistryRoundTrip(t *testing.T) {
+	for _, entry := range suiteRegistry {
+		id, err := idForSuite(entry.suite)
+		if err != nil {
+			t.Fatalf("idForSuite(%s): %v", entry.suite.Name(), err)
+		}
+		if id != entry.id {
+			t.Fatalf("idForSuite(%s) = %d, want %d", entry.suite.Name(), id, entry.id)
+		}
+
+		got, err := suiteByID(entry.id)
+		if err != nil {
+			t.Fatalf("suiteByID(%d): %v", entry.id, err)
+		}
+		if got.Name() != entry.suite.Name() {
+			t.Fatalf("suiteByID(%d).Name() = %s, want %s", entry.id, got.Name(), entry.suite.Name())
+		}
+	}
+
+	if _, err := suiteByID(255); err != ErrUnknownCipherSuite {
+		t.Fatalf("suiteByID(255) error = %v, want ErrUnknownCipherSuite", err)
+	}
+}